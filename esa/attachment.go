@@ -2,14 +2,15 @@ package esa
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+
+	"github.com/uobikiemukot/go-esa/esa/policy"
 )
 
 const (
@@ -22,6 +23,10 @@ const (
 // TeamService API docs: https://docs.esa.io/posts/102#4-0-0
 type AttachmentService struct {
 	client *Client
+	// uploader はファイル本体の実際のアップロード先。nilの場合は
+	// S3PresignUploader (esaが発行したpresigned POSTポリシーを使う既定の挙動)
+	// が使われる。SetAttachmentUploaderで差し替え可能。
+	uploader Uploader
 }
 
 // AttachmentPolicyResponse ファイルアップロードに必要なポリシーのレスポンス
@@ -46,90 +51,188 @@ type FormValue struct {
 	Acl                string `json:"acl"`
 }
 
-// getFileType ファイルのMIMEタイプ, サイズ, ベースパスを取得する
-func (a *AttachmentService) getFileInfo(path string) (url.Values, []byte, error) {
-	f, err := os.Open(path)
+// postAttachmentPolicy AWS S3にアップロードするための情報を取得する
+// (beta版の機能でAPIが用意されていない)
+//
+// 既知の制限: a.client.post はctxを受け取らないため、ctxがキャンセル/
+// タイムアウトした場合でもこの関数はリクエストの完了を待たずに ctx.Err() を
+// 返すだけで、裏で動くゴルーチンとその先のHTTPリクエストは中断されない。
+// サーバーが応答を返すかコネクションがタイムアウトするまでゴルーチンは
+// 残り続ける (バッファ付きチャネルなので送信自体がブロックすることはなく、
+// 自然にいつか終了はする)。a.client.post 自体がctxを受け取れるようになれば
+// ここもそれに合わせて修正すること。
+func (a *AttachmentService) postAttachmentPolicy(ctx context.Context, teamName string, values url.Values) (*AttachmentPolicyResponse, error) {
+	type policyResult struct {
+		policy *AttachmentPolicyResponse
+		err    error
+	}
+
+	resCh := make(chan policyResult, 1)
+	go func() {
+		var attachmentPolicyRes AttachmentPolicyResponse
+
+		teamURL := TeamURL + "/" + teamName + AttchmentPolicyURL
+		data := bytes.NewBufferString(values.Encode())
+
+		res, err := a.client.post(teamURL, PolicyBodyType, data, &attachmentPolicyRes)
+		if err != nil {
+			resCh <- policyResult{nil, err}
+			return
+		}
+		defer res.Body.Close()
+
+		resCh <- policyResult{&attachmentPolicyRes, nil}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-resCh:
+		return r.policy, r.err
+	}
+}
+
+// UploadAttachment r の内容を name という名前の添付ファイルとしてesaにアップロードする。
+// データ全体をメモリ上に保持せず io.Pipe 経由でS3へストリーミングするため、
+// 大きなファイルでもメモリ使用量は一定に保たれる。size と contentType は
+// 呼び出し側が事前に把握している値をそのまま渡す (サニフィングのための
+// バッファリングは行わない)。ctx のキャンセル/タイムアウトはポリシーの
+// 問い合わせとS3へのPOSTの両方に反映される。
+//
+// SetAttachmentUploaderでesa以外のUploader (PostPolicyUploaderなど) が
+// 設定されている場合、esaのpresign APIには一切依存しないため、esaへの
+// ポリシー問い合わせ・検証はスキップしてそのままUploaderに委譲する。
+// 既定のS3PresignUploaderを使う場合のみ、S3へのPOST前にポリシーの内容と
+// ファイルサイズ/Content-Typeを突き合わせて検証し、条件に反する場合は
+// ネットワークラウンドトリップを行わずエラーを返す。
+func (a *AttachmentService) UploadAttachment(ctx context.Context, teamName, name string, r io.Reader, size int64, contentType string) (string, error) {
+	if a.uploader != nil {
+		if _, ok := a.uploader.(*S3PresignUploader); !ok {
+			return a.uploader.Upload(ctx, nil, name, r, size, contentType)
+		}
+	}
+
+	values := url.Values{
+		"type": {contentType},
+		"name": {name},
+		"size": {fmt.Sprint(size)},
+	}
+
+	policyRes, err := a.postAttachmentPolicy(ctx, teamName, values)
 	if err != nil {
-		return nil, nil, err
+		return "", fmt.Errorf("postAttachmentPolicy Failed (values: %v): %w\n", values, err)
 	}
-	defer f.Close()
 
-	data, err := ioutil.ReadAll(f)
+	httpClient := a.client.Client
+	if u, ok := a.uploader.(*S3PresignUploader); ok && u.Client != nil {
+		httpClient = u.Client
+	}
+
+	return uploadWithPolicy(ctx, httpClient, policyRes, name, r, size, contentType)
+}
+
+// uploadWithPolicy は既に取得済みのpolicyResの条件を、S3へ実際に送るフォーム値
+// (Content-Typeはesaが発行したpolicyRes.Form.ContentType) とsizeに対して検証し、
+// 条件を満たす場合のみhttpClientでS3にPOSTする。contentType引数は検証には使わない。
+// UploadAttachmentから切り出してあるのは、esaへのポリシー問い合わせ (*Clientが
+// 必要) を経由せずに、この検証+アップロードの経路単体をテストできるようにするため。
+func uploadWithPolicy(ctx context.Context, httpClient *http.Client, policyRes *AttachmentPolicyResponse, name string, r io.Reader, size int64, contentType string) (string, error) {
+	pol, err := policy.Parse(policyRes.Form.Policy)
 	if err != nil {
-		return nil, nil, err
+		return "", fmt.Errorf("policy.Parse Failed: %v\n", err)
 	}
 
-	return url.Values{
-		"type": {http.DetectContentType(data)},
-		"name": {filepath.Base(path)},
-		"size": {fmt.Sprint(len(data))},
-	}, data, nil
+	if err := pol.Check(formValues(policyRes.Form), size); err != nil {
+		return "", fmt.Errorf("policy.Check Failed: %v\n", err)
+	}
+
+	return (&S3PresignUploader{Client: httpClient}).Upload(ctx, policyRes, name, r, size, contentType)
 }
 
-// postAttachmentPolicy AWS S3にアップロードするための情報を取得する
-// (beta版の機能でAPIが用意されていない)
-func (a *AttachmentService) postAttachmentPolicy(teamName string, values url.Values) (*AttachmentPolicyResponse, error) {
-	var attachmentPolicyRes AttachmentPolicyResponse
+// formValues はS3へ実際に送信するフォーム値をpolicy.Checkで検証できる形に変換する。
+func formValues(form FormValue) url.Values {
+	return url.Values{
+		"key":                 {form.Key},
+		"acl":                 {form.Acl},
+		"Content-Type":        {form.ContentType},
+		"Cache-Control":       {form.CacheControl},
+		"Content-Disposition": {form.ContentDisposition},
+		"AWSAccessKeyId":      {form.AWSAccessKeyId},
+	}
+}
 
-	teamURL := TeamURL + "/" + teamName + AttchmentPolicyURL
-	data := bytes.NewBufferString(values.Encode())
+// sniffContentType fの先頭512バイトのみを読んでMIMEタイプを判定し、
+// 読み取り位置を先頭に戻す。ファイル全体をメモリに読み込むことはない。
+func sniffContentType(f *os.File) (string, error) {
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(f, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
 
-	res, err := a.client.post(teamURL, PolicyBodyType, data, &attachmentPolicyRes)
-	if err != nil {
-		return nil, err
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
 	}
-	defer res.Body.Close()
 
-	return &attachmentPolicyRes, nil
+	return http.DetectContentType(sniff[:n]), nil
 }
 
-// UploadAttachmentFile ファイルをesaにアップロードする
-func (a *AttachmentService) UploadAttachmentFile(teamName string, path string) (string, error) {
-	var err error
+// UploadAttachmentFileContext pathのファイルをesaにアップロードする (ctx対応)。
+func (a *AttachmentService) UploadAttachmentFileContext(ctx context.Context, teamName string, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Open Failed (path: %s): %v\n", path, err)
+	}
+	defer f.Close()
 
-	values, data, err := a.getFileInfo(path)
+	info, err := f.Stat()
 	if err != nil {
-		return "", fmt.Errorf("getFileInfo Failed (path: %s): %v\n", path, err)
+		return "", fmt.Errorf("Stat Failed (path: %s): %v\n", path, err)
 	}
 
-	policy, err := a.postAttachmentPolicy(teamName, values)
+	contentType, err := sniffContentType(f)
 	if err != nil {
-		return "", fmt.Errorf("postAttachmentPolicy Failed (values: %v): %v\n", values, err)
+		return "", fmt.Errorf("Read Failed (path: %s): %v\n", path, err)
 	}
 
-	part := &bytes.Buffer{}
-	w := multipart.NewWriter(part)
-	defer w.Close()
+	return a.UploadAttachment(ctx, teamName, filepath.Base(path), f, info.Size(), contentType)
+}
 
-	w.WriteField("AWSAccessKeyId", policy.Form.AWSAccessKeyId)
-	w.WriteField("signature", policy.Form.Signature)
-	w.WriteField("policy", policy.Form.Policy)
-	w.WriteField("key", policy.Form.Key)
-	w.WriteField("Content-Type", policy.Form.ContentType)
-	w.WriteField("Cache-Control", policy.Form.CacheControl)
-	w.WriteField("Content-Disposition", policy.Form.ContentDisposition)
-	w.WriteField("acl", policy.Form.Acl)
+// UploadAttachmentFile ファイルをesaにアップロードする
+func (a *AttachmentService) UploadAttachmentFile(teamName string, path string) (string, error) {
+	return a.UploadAttachmentFileContext(context.Background(), teamName, path)
+}
 
-	file, err := w.CreateFormFile("file", filepath.Base(path))
+// InspectPolicy pathのファイルをアップロードする際に適用されるポリシーを取得し、
+// パース済みの *policy.Policy を返す。実際のアップロードは行わないため、
+// アップロード前に条件を確認したい呼び出し側のプリフライトに使える。
+func (a *AttachmentService) InspectPolicy(teamName, path string) (*policy.Policy, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return "", fmt.Errorf("CreateFormFile Failed: %v\n", err)
+		return nil, fmt.Errorf("Open Failed (path: %s): %v\n", path, err)
 	}
+	defer f.Close()
 
-	_, err = io.Copy(file, bytes.NewBuffer(data))
+	info, err := f.Stat()
 	if err != nil {
-		return "", fmt.Errorf("Copy Failed: %v\n", err)
+		return nil, fmt.Errorf("Stat Failed (path: %s): %v\n", path, err)
 	}
 
-	res, err := a.client.Client.Post(policy.Attachment.Endpoint, w.FormDataContentType(), part)
+	contentType, err := sniffContentType(f)
 	if err != nil {
-		return "", fmt.Errorf("Post Failed (endpoint: %s): %v\n", policy.Attachment.Endpoint, err)
+		return nil, fmt.Errorf("Read Failed (path: %s): %v\n", path, err)
+	}
+
+	values := url.Values{
+		"type": {contentType},
+		"name": {filepath.Base(path)},
+		"size": {fmt.Sprint(info.Size())},
 	}
-	defer res.Body.Close()
 
-	// ref: https://github.com/esaio/esa-ruby/blob/3431e02e967845cf4c12bbd5860312d7dda2771f/lib/esa/api_methods.rb#L181
-	if res.StatusCode != http.StatusNoContent {
-		return "", fmt.Errorf("HTTP status is not http.StatusNoContent: %v\n", http.StatusText(res.StatusCode))
+	policyRes, err := a.postAttachmentPolicy(context.Background(), teamName, values)
+	if err != nil {
+		return nil, fmt.Errorf("postAttachmentPolicy Failed (values: %v): %w\n", values, err)
 	}
 
-	return policy.Attachment.Url, nil
+	return policy.Parse(policyRes.Form.Policy)
 }
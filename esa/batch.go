@@ -0,0 +1,183 @@
+package esa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	maxUploadRetries = 3
+	retryBaseDelay   = 500 * time.Millisecond
+)
+
+// UploadOptions はUploadAttachmentsの挙動を制御する。
+type UploadOptions struct {
+	// Concurrency は同時にアップロードするワーカー数。0以下の場合は1として扱う。
+	Concurrency int
+	// Progress はファイルごとの進捗を通知するコールバック。nilなら呼ばれない。
+	Progress func(path string, bytesDone, bytesTotal int64)
+	// FailFast がtrueの場合、いずれかのファイルが致命的に失敗した時点で
+	// 残りの未着手のファイルをctxのキャンセル経由で打ち切る。
+	FailFast bool
+}
+
+// UploadResult はUploadAttachmentsにおける1ファイル分の結果。
+type UploadResult struct {
+	Path string
+	URL  string
+	Err  error
+}
+
+// UploadAttachments pathsの各ファイルをopts.Concurrencyで指定した数だけ
+// 並行してteamNameにアップロードする。5xx/ネットワーク障害は指数バックオフで
+// 最大maxUploadRetries回までリトライする。結果はpathsと同じ順序で返る。
+//
+// 全ワーカーはAttachmentServiceが保持する単一のhttp.Client (コネクション
+// プールを共有) 経由でポリシーを取得するため、ファイルごとに新規接続を
+// 張り直すことはない。opts.FailFastが設定されている場合、いずれかの
+// ファイルが（リトライを使い切るなどして）致命的に失敗した時点でctxを
+// キャンセルし、未着手のファイルをそれ以上処理しない。
+func (a *AttachmentService) UploadAttachments(ctx context.Context, teamName string, paths []string, opts UploadOptions) ([]UploadResult, error) {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]UploadResult, len(paths))
+	indexCh := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexCh {
+				path := paths[idx]
+				if err := ctx.Err(); err != nil {
+					results[idx] = UploadResult{Path: path, Err: err}
+					continue
+				}
+				url, err := a.uploadAttachmentWithRetry(ctx, teamName, path, opts.Progress)
+				results[idx] = UploadResult{Path: path, URL: url, Err: err}
+				if err != nil && opts.FailFast {
+					cancel()
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(indexCh)
+		for i := range paths {
+			select {
+			case indexCh <- i:
+			case <-ctx.Done():
+				for j := i; j < len(paths); j++ {
+					results[j] = UploadResult{Path: paths[j], Err: ctx.Err()}
+				}
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	return results, nil
+}
+
+// uploadAttachmentWithRetry は1ファイルをアップロードし、5xx/ネットワーク
+// エラーの場合のみ指数バックオフでリトライする。ポリシー違反などそれ以外の
+// エラーは即座に返す。
+func (a *AttachmentService) uploadAttachmentWithRetry(ctx context.Context, teamName, path string, progress func(path string, bytesDone, bytesTotal int64)) (string, error) {
+	var lastErr error
+
+	for attempt := 0; attempt <= maxUploadRetries; attempt++ {
+		if attempt > 0 {
+			delay := retryBaseDelay * time.Duration(uint(1)<<uint(attempt-1))
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+
+		attachmentURL, err := a.uploadAttachmentFileWithProgress(ctx, teamName, path, progress)
+		if err == nil {
+			return attachmentURL, nil
+		}
+
+		lastErr = err
+		if !isRetryableUploadErr(err) {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("UploadAttachment Failed after %d attempts (path: %s): %v\n", maxUploadRetries+1, path, lastErr)
+}
+
+// uploadAttachmentFileWithProgress はUploadAttachmentFileContextと同様に
+// pathを開いてアップロードするが、progressが設定されていれば読み込んだ
+// バイト数を都度通知する。
+func (a *AttachmentService) uploadAttachmentFileWithProgress(ctx context.Context, teamName, path string, progress func(path string, bytesDone, bytesTotal int64)) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Open Failed (path: %s): %v\n", path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("Stat Failed (path: %s): %v\n", path, err)
+	}
+
+	contentType, err := sniffContentType(f)
+	if err != nil {
+		return "", fmt.Errorf("Read Failed (path: %s): %v\n", path, err)
+	}
+
+	var r io.Reader = f
+	if progress != nil {
+		r = &progressReader{r: f, path: path, total: info.Size(), onRead: progress}
+	}
+
+	return a.UploadAttachment(ctx, teamName, filepath.Base(path), r, info.Size(), contentType)
+}
+
+// progressReader はReadを横取りして累計の読み込みバイト数をonReadに通知する。
+type progressReader struct {
+	r      io.Reader
+	path   string
+	total  int64
+	done   int64
+	onRead func(path string, bytesDone, bytesTotal int64)
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.r.Read(buf)
+	if n > 0 {
+		p.done += int64(n)
+		p.onRead(p.path, p.done, p.total)
+	}
+	return n, err
+}
+
+// isRetryableUploadErr はerrが5xx/ネットワーク由来の一時的な失敗かを判定する。
+func isRetryableUploadErr(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Retryable()
+	}
+
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
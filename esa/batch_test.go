@@ -0,0 +1,195 @@
+package esa
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// countingUploader is a fake Uploader used to exercise UploadAttachments'
+// retry/concurrency logic without touching the network or esa's Client.
+type countingUploader struct {
+	mu      sync.Mutex
+	calls   map[string]int
+	failN   int   // number of attempts to fail (per name) before succeeding
+	failErr error // error returned while failing
+}
+
+func (u *countingUploader) Upload(ctx context.Context, _ *AttachmentPolicyResponse, name string, r io.Reader, size int64, _ string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	u.mu.Lock()
+	if u.calls == nil {
+		u.calls = map[string]int{}
+	}
+	u.calls[name]++
+	n := u.calls[name]
+	u.mu.Unlock()
+
+	if n <= u.failN {
+		return "", u.failErr
+	}
+
+	if _, err := io.ReadAll(r); err != nil {
+		return "", err
+	}
+	return "https://example.invalid/" + name, nil
+}
+
+func (u *countingUploader) callCount(name string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.calls[name]
+}
+
+func writeTempFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile Failed: %v", err)
+	}
+	return path
+}
+
+func TestUploadAttachmentsRetriesRetryableError(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTempFile(t, dir, "ok.txt", "hello world")
+
+	up := &countingUploader{failN: 1, failErr: &StatusError{StatusCode: 503}}
+	a := &AttachmentService{uploader: up}
+
+	results, err := a.UploadAttachments(context.Background(), "team", []string{path}, UploadOptions{})
+	if err != nil {
+		t.Fatalf("UploadAttachments Failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	if results[0].Err != nil {
+		t.Fatalf("results[0].Err = %v, want nil", results[0].Err)
+	}
+	if results[0].URL == "" {
+		t.Error("results[0].URL is empty, want a URL")
+	}
+	if got := up.callCount("ok.txt"); got != 2 {
+		t.Errorf("Upload called %d times, want 2 (1 failure + 1 success)", got)
+	}
+}
+
+func TestUploadAttachmentsNonRetryableFailsImmediately(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTempFile(t, dir, "bad.txt", "nope")
+
+	up := &countingUploader{failN: 1000, failErr: errors.New("policy violation")}
+	a := &AttachmentService{uploader: up}
+
+	results, err := a.UploadAttachments(context.Background(), "team", []string{path}, UploadOptions{})
+	if err != nil {
+		t.Fatalf("UploadAttachments Failed: %v", err)
+	}
+	if results[0].Err == nil {
+		t.Fatal("results[0].Err = nil, want an error")
+	}
+	if got := up.callCount("bad.txt"); got != 1 {
+		t.Errorf("Upload called %d times, want 1 (non-retryable error must not retry)", got)
+	}
+}
+
+func TestUploadAttachmentsPreservesOrder(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{
+		writeTempFile(t, dir, "a.txt", "a"),
+		writeTempFile(t, dir, "b.txt", "b"),
+		writeTempFile(t, dir, "c.txt", "c"),
+	}
+
+	up := &countingUploader{}
+	a := &AttachmentService{uploader: up}
+
+	results, err := a.UploadAttachments(context.Background(), "team", paths, UploadOptions{Concurrency: 4})
+	if err != nil {
+		t.Fatalf("UploadAttachments Failed: %v", err)
+	}
+	if len(results) != len(paths) {
+		t.Fatalf("got %d results, want %d", len(results), len(paths))
+	}
+	for i, p := range paths {
+		if results[i].Path != p {
+			t.Errorf("results[%d].Path = %q, want %q", i, results[i].Path, p)
+		}
+		if results[i].Err != nil {
+			t.Errorf("results[%d].Err = %v, want nil", i, results[i].Err)
+		}
+	}
+}
+
+func TestUploadAttachmentsFailFastCancelsRemaining(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{
+		writeTempFile(t, dir, "a.txt", "a"),
+		writeTempFile(t, dir, "b.txt", "b"),
+		writeTempFile(t, dir, "c.txt", "c"),
+	}
+
+	up := &countingUploader{failN: 1000, failErr: errors.New("fatal")}
+	a := &AttachmentService{uploader: up}
+
+	results, err := a.UploadAttachments(context.Background(), "team", paths, UploadOptions{
+		Concurrency: 1,
+		FailFast:    true,
+	})
+	if err != nil {
+		t.Fatalf("UploadAttachments Failed: %v", err)
+	}
+
+	if results[0].Err == nil {
+		t.Fatal("results[0].Err = nil, want the fatal upload error")
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i].Err == nil {
+			t.Errorf("results[%d].Err = nil, want context.Canceled after FailFast", i)
+		}
+	}
+}
+
+func TestUploadAttachmentsProgress(t *testing.T) {
+	dir := t.TempDir()
+	content := "some bytes to upload"
+	path := writeTempFile(t, dir, "progress.txt", content)
+
+	up := &countingUploader{}
+	a := &AttachmentService{uploader: up}
+
+	var mu sync.Mutex
+	var lastDone, lastTotal int64
+	progress := func(p string, bytesDone, bytesTotal int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		lastDone, lastTotal = bytesDone, bytesTotal
+	}
+
+	results, err := a.UploadAttachments(context.Background(), "team", []string{path}, UploadOptions{
+		Progress: progress,
+	})
+	if err != nil {
+		t.Fatalf("UploadAttachments Failed: %v", err)
+	}
+	if results[0].Err != nil {
+		t.Fatalf("results[0].Err = %v, want nil", results[0].Err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if lastTotal != int64(len(content)) {
+		t.Errorf("lastTotal = %d, want %d", lastTotal, len(content))
+	}
+	if lastDone != lastTotal {
+		t.Errorf("lastDone = %d, want %d (fully read)", lastDone, lastTotal)
+	}
+}
@@ -0,0 +1,164 @@
+// Package policy は S3 Browser-Based POST で使われるアップロードポリシー
+// (base64エンコードされたJSON) のパースと検証を行う。
+//
+// SeaweedFS/MinIOなどS3互換サーバーのクライアントと同様のアプローチで、
+// アップロード先にPOSTする前にフォーム値とファイルサイズがポリシーの
+// 条件を満たしているかをローカルで検証できるようにする。
+package policy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ConditionType はポリシーの conditions 配列に現れる条件の種類を表す。
+type ConditionType string
+
+const (
+	ConditionEq                 ConditionType = "eq"
+	ConditionStartsWith         ConditionType = "starts-with"
+	ConditionContentLengthRange ConditionType = "content-length-range"
+)
+
+// Condition はポリシーの1つの条件を表す。
+//
+// JSONでは {"acl": "public-read"} のようなオブジェクト形式 (eqの省略形)か、
+// ["starts-with", "$key", "uploads/"] のような配列形式のどちらかで表現される。
+type Condition struct {
+	Type  ConditionType
+	Key   string
+	Value string
+	Min   int64
+	Max   int64
+}
+
+// UnmarshalJSON はオブジェクト形式・配列形式の両方に対応する。
+func (c *Condition) UnmarshalJSON(b []byte) error {
+	var arr []interface{}
+	if err := json.Unmarshal(b, &arr); err == nil {
+		return c.unmarshalArray(arr)
+	}
+
+	var obj map[string]string
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return fmt.Errorf("policy: invalid condition: %s", b)
+	}
+	for k, v := range obj {
+		c.Type = ConditionEq
+		c.Key = k
+		c.Value = v
+	}
+	return nil
+}
+
+func (c *Condition) unmarshalArray(arr []interface{}) error {
+	if len(arr) != 3 {
+		return fmt.Errorf("policy: invalid condition array: %v", arr)
+	}
+
+	op, ok := arr[0].(string)
+	if !ok {
+		return fmt.Errorf("policy: invalid condition operator: %v", arr[0])
+	}
+
+	switch ConditionType(op) {
+	case ConditionEq, ConditionStartsWith:
+		key, _ := arr[1].(string)
+		val, _ := arr[2].(string)
+		c.Type = ConditionType(op)
+		c.Key = strings.TrimPrefix(key, "$")
+		c.Value = val
+	case ConditionContentLengthRange:
+		min, _ := arr[1].(float64)
+		max, _ := arr[2].(float64)
+		c.Type = ConditionContentLengthRange
+		c.Min = int64(min)
+		c.Max = int64(max)
+	default:
+		return fmt.Errorf("policy: unsupported condition operator: %s", op)
+	}
+	return nil
+}
+
+// Policy はS3 POSTポリシーのうち、検証に必要な情報を保持する。
+type Policy struct {
+	Expiration string      `json:"expiration"`
+	Conditions []Condition `json:"conditions"`
+}
+
+// Parse はbase64エンコードされたポリシーJSON (FormValue.Policy) をデコードする。
+func Parse(b64 string) (*Policy, error) {
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("policy: base64 decode failed: %v", err)
+	}
+
+	var p Policy
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, fmt.Errorf("policy: json decode failed: %v", err)
+	}
+
+	return &p, nil
+}
+
+// Check はform (アップロード先に送るフォーム値) とファイルサイズがポリシーの
+// 全条件を満たしているか検証する。条件に反している場合はどの条件に反したかを
+// 説明するエラーを返す。
+func (p *Policy) Check(form url.Values, size int64) error {
+	for _, c := range p.Conditions {
+		if err := c.check(form, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Condition) check(form url.Values, size int64) error {
+	switch c.Type {
+	case ConditionEq:
+		// フィールド自体がform (実際に送信するフォーム値) に存在しない場合は
+		// 検証しようがないためスキップするが、値が空文字列で「存在する」場合は
+		// 実際に送る値なので違反を見逃さないようきちんと検証する。
+		if !form.Has(c.Key) {
+			return nil
+		}
+		if got := form.Get(c.Key); got != c.Value {
+			return fmt.Errorf("policy: %s %q does not equal %q", c.Key, got, c.Value)
+		}
+	case ConditionStartsWith:
+		if !form.Has(c.Key) {
+			return nil
+		}
+		got := form.Get(c.Key)
+		if !strings.HasPrefix(got, c.Value) {
+			return fmt.Errorf("policy: %s %q does not satisfy starts-with %q", c.Key, got, c.Value)
+		}
+	case ConditionContentLengthRange:
+		if size > c.Max {
+			return fmt.Errorf("policy: file size %s exceeds policy max %s", humanSize(size), humanSize(c.Max))
+		}
+		if size < c.Min {
+			return fmt.Errorf("policy: file size %s is below policy min %s", humanSize(size), humanSize(c.Min))
+		}
+	}
+	return nil
+}
+
+// humanSize はバイト数を "12MB" のような人間が読みやすい形式に変換する。
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for n2 := n / unit; n2 >= unit; n2 /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.0f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
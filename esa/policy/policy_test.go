@@ -0,0 +1,188 @@
+package policy
+
+import (
+	"encoding/base64"
+	"net/url"
+	"testing"
+)
+
+func encodePolicy(t *testing.T, doc string) string {
+	t.Helper()
+	return base64.StdEncoding.EncodeToString([]byte(doc))
+}
+
+func TestConditionUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want Condition
+	}{
+		{
+			name: "object form (eq)",
+			json: `{"acl": "public-read"}`,
+			want: Condition{Type: ConditionEq, Key: "acl", Value: "public-read"},
+		},
+		{
+			name: "array form (eq)",
+			json: `["eq", "$bucket", "my-bucket"]`,
+			want: Condition{Type: ConditionEq, Key: "bucket", Value: "my-bucket"},
+		},
+		{
+			name: "array form (starts-with)",
+			json: `["starts-with", "$Content-Type", "image/"]`,
+			want: Condition{Type: ConditionStartsWith, Key: "Content-Type", Value: "image/"},
+		},
+		{
+			name: "array form (content-length-range)",
+			json: `["content-length-range", 0, 10485760]`,
+			want: Condition{Type: ConditionContentLengthRange, Min: 0, Max: 10485760},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Condition
+			if err := got.UnmarshalJSON([]byte(tt.json)); err != nil {
+				t.Fatalf("UnmarshalJSON Failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionUnmarshalJSONError(t *testing.T) {
+	tests := []string{
+		`["starts-with", "$key"]`,
+		`["unsupported", "$key", "value"]`,
+		`123`,
+	}
+
+	for _, in := range tests {
+		var c Condition
+		if err := c.UnmarshalJSON([]byte(in)); err == nil {
+			t.Errorf("UnmarshalJSON(%s) expected error, got nil", in)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	doc := `{"expiration": "2026-07-29T00:00:00.000Z", "conditions": [` +
+		`{"bucket": "my-bucket"}, ` +
+		`["starts-with", "$key", "uploads/"], ` +
+		`["content-length-range", 0, 10485760]` +
+		`]}`
+
+	p, err := Parse(encodePolicy(t, doc))
+	if err != nil {
+		t.Fatalf("Parse Failed: %v", err)
+	}
+	if len(p.Conditions) != 3 {
+		t.Fatalf("got %d conditions, want 3", len(p.Conditions))
+	}
+}
+
+func TestParseInvalidBase64(t *testing.T) {
+	if _, err := Parse("not-base64!!"); err == nil {
+		t.Error("Parse expected error for invalid base64, got nil")
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	doc := `{"expiration": "2026-07-29T00:00:00.000Z", "conditions": [` +
+		`{"acl": "public-read"}, ` +
+		`["starts-with", "$key", "uploads/"], ` +
+		`["starts-with", "$Content-Type", "image/"], ` +
+		`["content-length-range", 0, 10485760]` +
+		`]}`
+
+	p, err := Parse(encodePolicy(t, doc))
+	if err != nil {
+		t.Fatalf("Parse Failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		form    url.Values
+		size    int64
+		wantErr bool
+	}{
+		{
+			name: "satisfies all conditions",
+			form: url.Values{
+				"acl":          {"public-read"},
+				"key":          {"uploads/file.png"},
+				"Content-Type": {"image/png"},
+			},
+			size:    1024,
+			wantErr: false,
+		},
+		{
+			name: "acl does not equal",
+			form: url.Values{
+				"acl":          {"private"},
+				"key":          {"uploads/file.png"},
+				"Content-Type": {"image/png"},
+			},
+			size:    1024,
+			wantErr: true,
+		},
+		{
+			name: "key does not satisfy starts-with",
+			form: url.Values{
+				"acl":          {"public-read"},
+				"key":          {"other/file.png"},
+				"Content-Type": {"image/png"},
+			},
+			size:    1024,
+			wantErr: true,
+		},
+		{
+			name: "content-type present but blank still fails starts-with",
+			form: url.Values{
+				"acl":          {"public-read"},
+				"key":          {"uploads/file.png"},
+				"Content-Type": {""},
+			},
+			size:    1024,
+			wantErr: true,
+		},
+		{
+			name: "size exceeds max",
+			form: url.Values{
+				"acl":          {"public-read"},
+				"key":          {"uploads/file.png"},
+				"Content-Type": {"image/png"},
+			},
+			size:    20 * 1024 * 1024,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(tt.form, tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{512, "512B"},
+		{10 * 1024 * 1024, "10MB"},
+		{1024, "1KB"},
+	}
+
+	for _, tt := range tests {
+		if got := humanSize(tt.n); got != tt.want {
+			t.Errorf("humanSize(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
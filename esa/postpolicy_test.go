@@ -0,0 +1,220 @@
+package esa
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSignV2 checks signV2 against an independent HMAC-SHA1 + base64
+// computation, so a regression in the helper (wrong hash, wrong encoding,
+// argument order, ...) gets caught even though no third-party vector is
+// hardcoded here.
+func TestSignV2(t *testing.T) {
+	secret := "uV3F3YluFJax1cknvbcGwgjvx4QpvB6L"
+	encodedPolicy := base64.StdEncoding.EncodeToString([]byte(`{"expiration":"2026-07-29T00:00:00.000Z"}`))
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(encodedPolicy))
+	want := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	if got := signV2(secret, encodedPolicy); got != want {
+		t.Errorf("signV2() = %q, want %q", got, want)
+	}
+}
+
+// TestSignV4 checks signV4 against an independent implementation of AWS's
+// documented SigV4 key-derivation chain (date -> region -> service ->
+// aws4_request), so it catches mistakes such as a wrong service name or a
+// missing "AWS4" prefix on the secret.
+func TestSignV4(t *testing.T) {
+	secret := "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY"
+	region := "us-east-1"
+	ts := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	encodedPolicy := base64.StdEncoding.EncodeToString([]byte(`{"expiration":"2026-07-29T00:00:00.000Z"}`))
+
+	hmacSHA256 := func(key, data []byte) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+	dateKey := hmacSHA256([]byte("AWS4"+secret), []byte(ts.Format("20060102")))
+	regionKey := hmacSHA256(dateKey, []byte(region))
+	serviceKey := hmacSHA256(regionKey, []byte("s3"))
+	signingKey := hmacSHA256(serviceKey, []byte("aws4_request"))
+	want := hex.EncodeToString(hmacSHA256(signingKey, []byte(encodedPolicy)))
+
+	if got := signV4(secret, ts, region, encodedPolicy); got != want {
+		t.Errorf("signV4() = %q, want %q", got, want)
+	}
+}
+
+func TestPostPolicyUploaderUploadSigV2(t *testing.T) {
+	var gotForm map[string][]string
+	var gotFile string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("ParseMultipartForm Failed: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		gotForm = map[string][]string(r.MultipartForm.Value)
+
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("FormFile Failed: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		buf := make([]byte, 32)
+		n, _ := f.Read(buf)
+		gotFile = string(buf[:n])
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	u := &PostPolicyUploader{
+		Endpoint:  srv.URL,
+		Bucket:    "my-bucket",
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "secret",
+		Client:    srv.Client(),
+	}
+
+	gotURL, err := u.Upload(context.Background(), nil, "uploads/hello.txt", strings.NewReader("hello"), 5, "text/plain")
+	if err != nil {
+		t.Fatalf("Upload Failed: %v", err)
+	}
+	if want := srv.URL + "/uploads/hello.txt"; gotURL != want {
+		t.Errorf("url = %q, want %q", gotURL, want)
+	}
+	if gotFile != "hello" {
+		t.Errorf("uploaded file content = %q, want %q", gotFile, "hello")
+	}
+	if got := gotForm["bucket"]; len(got) != 1 || got[0] != "my-bucket" {
+		t.Errorf("form field bucket = %v, want [my-bucket]", got)
+	}
+	if got := gotForm["Content-Type"]; len(got) != 1 || got[0] != "text/plain" {
+		t.Errorf("form field Content-Type = %v, want [text/plain]", got)
+	}
+	if got := gotForm["AWSAccessKeyId"]; len(got) != 1 || got[0] != "AKIDEXAMPLE" {
+		t.Errorf("form field AWSAccessKeyId = %v, want [AKIDEXAMPLE]", got)
+	}
+
+	encodedPolicy := gotForm["policy"][0]
+	wantSig := signV2(u.SecretKey, encodedPolicy)
+	if got := gotForm["signature"]; len(got) != 1 || got[0] != wantSig {
+		t.Errorf("form field signature = %v, want [%s]", got, wantSig)
+	}
+
+	policyDoc, err := base64.StdEncoding.DecodeString(encodedPolicy)
+	if err != nil {
+		t.Fatalf("DecodeString Failed: %v", err)
+	}
+	var decoded struct {
+		Conditions []interface{} `json:"conditions"`
+	}
+	if err := json.Unmarshal(policyDoc, &decoded); err != nil {
+		t.Fatalf("Unmarshal Failed: %v", err)
+	}
+	var sawContentTypeCondition bool
+	for _, c := range decoded.Conditions {
+		if m, ok := c.(map[string]interface{}); ok {
+			if v, ok := m["Content-Type"]; ok && v == "text/plain" {
+				sawContentTypeCondition = true
+			}
+		}
+	}
+	if !sawContentTypeCondition {
+		t.Errorf("policy conditions = %v, want a Content-Type condition for text/plain", decoded.Conditions)
+	}
+}
+
+func TestPostPolicyUploaderUploadSigV4(t *testing.T) {
+	var gotForm map[string][]string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("ParseMultipartForm Failed: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		gotForm = map[string][]string(r.MultipartForm.Value)
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	u := &PostPolicyUploader{
+		Endpoint:  srv.URL,
+		Bucket:    "my-bucket",
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "secret",
+		Region:    "us-east-1",
+		Client:    srv.Client(),
+	}
+
+	if _, err := u.Upload(context.Background(), nil, "hello.txt", strings.NewReader("hello"), 5, "image/png"); err != nil {
+		t.Fatalf("Upload Failed: %v", err)
+	}
+
+	if got := gotForm["x-amz-algorithm"]; len(got) != 1 || got[0] != "AWS4-HMAC-SHA256" {
+		t.Errorf("form field x-amz-algorithm = %v, want [AWS4-HMAC-SHA256]", got)
+	}
+	if len(gotForm["x-amz-credential"]) != 1 || !strings.Contains(gotForm["x-amz-credential"][0], "/us-east-1/s3/aws4_request") {
+		t.Errorf("form field x-amz-credential = %v, want to contain /us-east-1/s3/aws4_request", gotForm["x-amz-credential"])
+	}
+	if got := gotForm["Content-Type"]; len(got) != 1 || got[0] != "image/png" {
+		t.Errorf("form field Content-Type = %v, want [image/png]", got)
+	}
+}
+
+func TestPostPolicyUploaderUploadServerError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	u := &PostPolicyUploader{Endpoint: srv.URL, Bucket: "b", AccessKey: "a", SecretKey: "s", Client: srv.Client()}
+	_, err := u.Upload(context.Background(), nil, "x.txt", strings.NewReader("x"), 1, "text/plain")
+	if err == nil {
+		t.Fatal("Upload expected error, got nil")
+	}
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("Upload error = %v, want *StatusError", err)
+	}
+	if !statusErr.Retryable() {
+		t.Errorf("StatusError(%d).Retryable() = false, want true", statusErr.StatusCode)
+	}
+}
+
+// TestPostPolicyUploaderUsesCustomClient confirms that Upload actually POSTs
+// through u.Client rather than ignoring it: the server only trusts its own
+// test certificate, so if Upload fell back to http.DefaultClient, the TLS
+// handshake (and therefore the whole upload) would fail.
+func TestPostPolicyUploaderUsesCustomClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	u := &PostPolicyUploader{Endpoint: srv.URL, Bucket: "b", AccessKey: "a", SecretKey: "s", Client: srv.Client()}
+	if _, err := u.Upload(context.Background(), nil, "x.txt", strings.NewReader("x"), 1, "text/plain"); err != nil {
+		t.Fatalf("Upload Failed: %v", err)
+	}
+}
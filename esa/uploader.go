@@ -0,0 +1,294 @@
+package esa
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Uploader はファイル本体を実際に送り届ける先を抽象化する。
+// SetAttachmentUploaderで既定のS3PresignUploader以外が設定されている場合、
+// AttachmentService.UploadAttachment はesaのpresign APIへの問い合わせ・検証を
+// 一切行わず、直接Uploaderに委譲する。
+type Uploader interface {
+	// Upload はrの内容 (size バイト, MIMEタイプcontentType) をnameという名前で
+	// アップロードし、アップロード後のURLを返す。policyはesaから取得した
+	// ポリシーで、S3PresignUploader以外 (esaを経由しない実装) ではnilになる。
+	Upload(ctx context.Context, policy *AttachmentPolicyResponse, name string, r io.Reader, size int64, contentType string) (string, error)
+}
+
+// SetAttachmentUploader 添付ファイルのアップロード先をuploaderに差し替える。
+// nilを渡すと既定のS3PresignUploader (esaのpresigned POST) に戻る。
+func (c *Client) SetAttachmentUploader(uploader Uploader) {
+	c.Attachment.uploader = uploader
+}
+
+// S3PresignUploader はesaが発行したpresigned POSTポリシーをそのまま使って
+// AWS S3にアップロードする、既定のUploader実装。
+type S3PresignUploader struct {
+	Client *http.Client
+}
+
+// Upload はio.Pipe + multipart.Writerでファイル本体をストリーミングしながら
+// policyの内容をフォームに詰めてpolicy.Attachment.EndpointにPOSTする。
+// Content-Typeはesaが発行したpolicy.Form.ContentTypeをそのまま使うため、
+// contentType引数は参照しない (Uploaderインターフェースとの整合のためだけに受け取る)。
+func (u *S3PresignUploader) Upload(ctx context.Context, policy *AttachmentPolicyResponse, name string, r io.Reader, size int64, contentType string) (string, error) {
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(writeAttachmentForm(w, policy.Form, name, r))
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, policy.Attachment.Endpoint, pr)
+	if err != nil {
+		return "", fmt.Errorf("NewRequestWithContext Failed: %w\n", err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	res, err := u.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Post Failed (endpoint: %s): %w\n", policy.Attachment.Endpoint, err)
+	}
+	defer res.Body.Close()
+
+	// ref: https://github.com/esaio/esa-ruby/blob/3431e02e967845cf4c12bbd5860312d7dda2771f/lib/esa/api_methods.rb#L181
+	if res.StatusCode != http.StatusNoContent {
+		return "", &StatusError{StatusCode: res.StatusCode}
+	}
+
+	return policy.Attachment.Url, nil
+}
+
+// StatusError はアップロード先のHTTPレスポンスが期待したステータスで
+// なかったことを表す。UploadAttachmentsはRetryable()を見て5xxのみ
+// リトライする。
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP status is not the expected one: %s", http.StatusText(e.StatusCode))
+}
+
+// Retryable はこのエラーが一時的なもの (5xx) としてリトライしてよいかを返す。
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode >= http.StatusInternalServerError
+}
+
+// writeAttachmentForm policyの内容とファイル本体をmultipart.Writerに書き込む。
+// io.Pipeの書き込み側で呼ばれるゴルーチンから使う想定で、常にwを閉じて返る。
+func writeAttachmentForm(w *multipart.Writer, form FormValue, name string, r io.Reader) error {
+	defer w.Close()
+
+	fields := []struct {
+		key, value string
+	}{
+		{"AWSAccessKeyId", form.AWSAccessKeyId},
+		{"signature", form.Signature},
+		{"policy", form.Policy},
+		{"key", form.Key},
+		{"Content-Type", form.ContentType},
+		{"Cache-Control", form.CacheControl},
+		{"Content-Disposition", form.ContentDisposition},
+		{"acl", form.Acl},
+	}
+	for _, f := range fields {
+		if err := w.WriteField(f.key, f.value); err != nil {
+			return fmt.Errorf("WriteField Failed (key: %s): %v\n", f.key, err)
+		}
+	}
+
+	file, err := w.CreateFormFile("file", name)
+	if err != nil {
+		return fmt.Errorf("CreateFormFile Failed: %v\n", err)
+	}
+
+	if _, err := io.Copy(file, r); err != nil {
+		return fmt.Errorf("Copy Failed: %v\n", err)
+	}
+
+	return nil
+}
+
+// PostPolicyUploader はesaのpresign APIを経由せず、AWS S3互換サーバー
+// (SeaweedFS, MinIOなど) に対してブラウザベースのPOSTアップロードを行う。
+// policy/signatureはesaから取得せずローカルで都度計算する。
+//
+// Regionが空の場合はAWS署名バージョン2 (HMAC-SHA1) 、設定されている場合は
+// バージョン4 (HMAC-SHA256の導出鍵チェーン) でpolicyに署名する。
+type PostPolicyUploader struct {
+	// Endpoint はPOST先のURL (例: "https://s3.amazonaws.com/my-bucket" や
+	// "http://127.0.0.1:8333/my-bucket")
+	Endpoint  string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Region が空文字列の場合はsigv2、それ以外はsigv4で署名する。
+	Region string
+	// Acl はデフォルトで "public-read"
+	Acl string
+	// Expires はポリシーの有効期限。デフォルトは15分。
+	Expires time.Duration
+	// Client はPOSTに使うhttp.Client。nilの場合はhttp.DefaultClientを使う。
+	// 自己署名証明書のSeaweedFS/MinIOやカスタムタイムアウトが必要な場合に設定する。
+	Client *http.Client
+}
+
+func (u *PostPolicyUploader) httpClient() *http.Client {
+	if u.Client != nil {
+		return u.Client
+	}
+	return http.DefaultClient
+}
+
+func (u *PostPolicyUploader) acl() string {
+	if u.Acl != "" {
+		return u.Acl
+	}
+	return "public-read"
+}
+
+func (u *PostPolicyUploader) expires() time.Duration {
+	if u.Expires != 0 {
+		return u.Expires
+	}
+	return 15 * time.Minute
+}
+
+// Upload はpolicy/signatureをローカルで計算し、rの内容をu.Endpointに
+// ブラウザベースのPOSTでアップロードする。policy引数 (esaのレスポンス) は使わない。
+func (u *PostPolicyUploader) Upload(ctx context.Context, _ *AttachmentPolicyResponse, name string, r io.Reader, size int64, contentType string) (string, error) {
+	now := time.Now().UTC()
+	expiration := now.Add(u.expires()).Format("2006-01-02T15:04:05.000Z")
+
+	conditions := []interface{}{
+		map[string]string{"bucket": u.Bucket},
+		map[string]string{"acl": u.acl()},
+		map[string]string{"Content-Type": contentType},
+		[]interface{}{"starts-with", "$key", ""},
+		[]interface{}{"content-length-range", 0, size},
+	}
+
+	form := url.Values{}
+	form.Set("key", name)
+	form.Set("acl", u.acl())
+	form.Set("bucket", u.Bucket)
+	form.Set("Content-Type", contentType)
+
+	if u.Region == "" {
+		conditions = append(conditions, map[string]string{"AWSAccessKeyId": u.AccessKey})
+	} else {
+		credential := fmt.Sprintf("%s/%s/%s/s3/aws4_request", u.AccessKey, now.Format("20060102"), u.Region)
+		amzDate := now.Format("20060102T150405Z")
+		conditions = append(conditions,
+			map[string]string{"x-amz-credential": credential},
+			map[string]string{"x-amz-date": amzDate},
+			map[string]string{"x-amz-algorithm": "AWS4-HMAC-SHA256"},
+		)
+	}
+
+	policyDoc, err := json.Marshal(map[string]interface{}{
+		"expiration": expiration,
+		"conditions": conditions,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Marshal Failed: %v\n", err)
+	}
+	encodedPolicy := base64.StdEncoding.EncodeToString(policyDoc)
+	form.Set("policy", encodedPolicy)
+
+	if u.Region == "" {
+		form.Set("AWSAccessKeyId", u.AccessKey)
+		form.Set("signature", signV2(u.SecretKey, encodedPolicy))
+	} else {
+		credential := fmt.Sprintf("%s/%s/%s/s3/aws4_request", u.AccessKey, now.Format("20060102"), u.Region)
+		form.Set("x-amz-credential", credential)
+		form.Set("x-amz-date", now.Format("20060102T150405Z"))
+		form.Set("x-amz-algorithm", "AWS4-HMAC-SHA256")
+		form.Set("x-amz-signature", signV4(u.SecretKey, now, u.Region, encodedPolicy))
+	}
+
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(writePostPolicyForm(w, form, name, r))
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.Endpoint, pr)
+	if err != nil {
+		return "", fmt.Errorf("NewRequestWithContext Failed: %w\n", err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	res, err := u.httpClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Post Failed (endpoint: %s): %w\n", u.Endpoint, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusOK {
+		return "", &StatusError{StatusCode: res.StatusCode}
+	}
+
+	return u.Endpoint + "/" + name, nil
+}
+
+// writePostPolicyForm formの内容とファイル本体をmultipart.Writerに書き込む。
+func writePostPolicyForm(w *multipart.Writer, form url.Values, name string, r io.Reader) error {
+	defer w.Close()
+
+	for key := range form {
+		if err := w.WriteField(key, form.Get(key)); err != nil {
+			return fmt.Errorf("WriteField Failed (key: %s): %v\n", key, err)
+		}
+	}
+
+	file, err := w.CreateFormFile("file", name)
+	if err != nil {
+		return fmt.Errorf("CreateFormFile Failed: %v\n", err)
+	}
+
+	if _, err := io.Copy(file, r); err != nil {
+		return fmt.Errorf("Copy Failed: %v\n", err)
+	}
+
+	return nil
+}
+
+// signV2 はAWS署名バージョン2でpolicyに署名する (HMAC-SHA1 + base64)。
+func signV2(secret, encodedPolicy string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(encodedPolicy))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signV4 はAWS署名バージョン4でpolicyに署名する。日付・リージョン・サービス名
+// ("s3") ・終端文字列 ("aws4_request") から導出した署名鍵でencodedPolicyを
+// HMAC-SHA256し、16進文字列にして返す。
+func signV4(secret string, t time.Time, region, encodedPolicy string) string {
+	hmacSHA256 := func(key, data []byte) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+
+	dateKey := hmacSHA256([]byte("AWS4"+secret), []byte(t.Format("20060102")))
+	regionKey := hmacSHA256(dateKey, []byte(region))
+	serviceKey := hmacSHA256(regionKey, []byte("s3"))
+	signingKey := hmacSHA256(serviceKey, []byte("aws4_request"))
+
+	return hex.EncodeToString(hmacSHA256(signingKey, []byte(encodedPolicy)))
+}
@@ -0,0 +1,112 @@
+package esa
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestS3PresignUploaderUpload(t *testing.T) {
+	var gotFields map[string][]string
+	var gotFile string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("ParseMultipartForm Failed: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		gotFields = map[string][]string(r.MultipartForm.Value)
+
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("FormFile Failed: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		buf := make([]byte, 32)
+		n, _ := f.Read(buf)
+		gotFile = string(buf[:n])
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	policy := &AttachmentPolicyResponse{
+		Attachment: AttachmentValue{Endpoint: srv.URL, Url: srv.URL + "/uploads/hello.txt"},
+		Form: FormValue{
+			AWSAccessKeyId: "AKIDEXAMPLE",
+			Signature:      "sig",
+			Policy:         "policy-doc",
+			Key:            "uploads/hello.txt",
+			ContentType:    "text/plain",
+			Acl:            "public-read",
+		},
+	}
+
+	u := &S3PresignUploader{Client: srv.Client()}
+	url, err := u.Upload(context.Background(), policy, "hello.txt", strings.NewReader("hello"), 5, "text/plain")
+	if err != nil {
+		t.Fatalf("Upload Failed: %v", err)
+	}
+	if url != policy.Attachment.Url {
+		t.Errorf("url = %q, want %q", url, policy.Attachment.Url)
+	}
+	if gotFile != "hello" {
+		t.Errorf("uploaded file content = %q, want %q", gotFile, "hello")
+	}
+	if got := gotFields["key"]; len(got) != 1 || got[0] != "uploads/hello.txt" {
+		t.Errorf("form field key = %v, want [uploads/hello.txt]", got)
+	}
+	if got := gotFields["acl"]; len(got) != 1 || got[0] != "public-read" {
+		t.Errorf("form field acl = %v, want [public-read]", got)
+	}
+}
+
+func TestS3PresignUploaderUploadServerError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	policy := &AttachmentPolicyResponse{
+		Attachment: AttachmentValue{Endpoint: srv.URL, Url: srv.URL + "/x"},
+	}
+
+	u := &S3PresignUploader{Client: srv.Client()}
+	_, err := u.Upload(context.Background(), policy, "x.txt", strings.NewReader("x"), 1, "text/plain")
+	if err == nil {
+		t.Fatal("Upload expected error, got nil")
+	}
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("Upload error = %v, want *StatusError", err)
+	}
+	if !statusErr.Retryable() {
+		t.Errorf("StatusError(%d).Retryable() = false, want true", statusErr.StatusCode)
+	}
+}
+
+func TestS3PresignUploaderUploadContextCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	policy := &AttachmentPolicyResponse{
+		Attachment: AttachmentValue{Endpoint: srv.URL},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	u := &S3PresignUploader{Client: srv.Client()}
+	if _, err := u.Upload(ctx, policy, "x.txt", strings.NewReader("x"), 1, "text/plain"); err == nil {
+		t.Fatal("Upload expected error for canceled ctx, got nil")
+	}
+}
@@ -0,0 +1,111 @@
+package esa
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// These tests drive UploadAttachment's default (uploader == nil) path through
+// uploadWithPolicy: policy.Parse -> policy.Check -> S3PresignUploader.Upload,
+// against a fake S3 endpoint, using a hand-built AttachmentPolicyResponse in
+// place of one fetched from esa.
+//
+// They intentionally stop short of also faking the esa policy-fetch leg
+// (a.postAttachmentPolicy, via a.client.post): Client and TeamURL are not
+// part of this pruned snapshot (they live in client.go, which isn't present
+// here), and TeamURL is consumed as a fixed base URL rather than anything
+// configurable, so there is no seam to redirect that leg at a local test
+// server without guessing at client.go's unseen internals.
+
+func newTestPolicyResponse(t *testing.T, s3URL string) *AttachmentPolicyResponse {
+	t.Helper()
+	doc := `{"expiration": "2026-07-29T00:00:00.000Z", "conditions": [` +
+		`{"acl": "public-read"}, ` +
+		`["starts-with", "$key", "uploads/"], ` +
+		`["starts-with", "$Content-Type", "image/"], ` +
+		`["content-length-range", 0, 1048576]` +
+		`]}`
+	encodedPolicy := base64.StdEncoding.EncodeToString([]byte(doc))
+
+	return &AttachmentPolicyResponse{
+		Attachment: AttachmentValue{Endpoint: s3URL, Url: s3URL + "/uploads/hello.png"},
+		Form: FormValue{
+			AWSAccessKeyId: "AKIDEXAMPLE",
+			Signature:      "sig",
+			Policy:         encodedPolicy,
+			Key:            "uploads/hello.png",
+			ContentType:    "image/png",
+			Acl:            "public-read",
+		},
+	}
+}
+
+func TestUploadWithPolicySuccess(t *testing.T) {
+	var gotFile string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("ParseMultipartForm Failed: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("FormFile Failed: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		buf := make([]byte, 32)
+		n, _ := f.Read(buf)
+		gotFile = string(buf[:n])
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	policyRes := newTestPolicyResponse(t, srv.URL)
+
+	url, err := uploadWithPolicy(context.Background(), srv.Client(), policyRes, "hello.png", strings.NewReader("hello"), 5, "image/png")
+	if err != nil {
+		t.Fatalf("uploadWithPolicy Failed: %v", err)
+	}
+	if url != policyRes.Attachment.Url {
+		t.Errorf("url = %q, want %q", url, policyRes.Attachment.Url)
+	}
+	if gotFile != "hello" {
+		t.Errorf("uploaded file content = %q, want %q", gotFile, "hello")
+	}
+}
+
+func TestUploadWithPolicyRejectsOversizedFileWithoutNetworkCall(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("unexpected request reached the S3 endpoint; oversized file must be rejected before the network POST")
+	}))
+	defer srv.Close()
+
+	policyRes := newTestPolicyResponse(t, srv.URL)
+
+	_, err := uploadWithPolicy(context.Background(), srv.Client(), policyRes, "hello.png", strings.NewReader("hello"), 10*1024*1024, "image/png")
+	if err == nil {
+		t.Fatal("uploadWithPolicy expected error for oversized file, got nil")
+	}
+}
+
+func TestUploadWithPolicyRejectsContentTypeMismatchWithoutNetworkCall(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("unexpected request reached the S3 endpoint; content-type mismatch must be rejected before the network POST")
+	}))
+	defer srv.Close()
+
+	policyRes := newTestPolicyResponse(t, srv.URL)
+	policyRes.Form.ContentType = "application/pdf"
+
+	_, err := uploadWithPolicy(context.Background(), srv.Client(), policyRes, "hello.png", strings.NewReader("hello"), 5, "application/pdf")
+	if err == nil {
+		t.Fatal("uploadWithPolicy expected error for content-type not matching the image/* policy condition, got nil")
+	}
+}